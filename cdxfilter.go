@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CDXFilters bundles every flag that narrows a CDX query server-side
+// (-from, -to, -status, -mime, -filter, -limit, -collapse), so the volume
+// pulled for a narrow query (e.g. only 2023 JSON endpoints) stays small
+// instead of filtering client-side after the fact.
+type CDXFilters struct {
+	From     string
+	To       string
+	Status   string
+	Mime     string
+	Extra    []string // raw "key:regex" filters from repeatable -filter
+	Limit    int
+	Collapse string
+}
+
+// QueryString renders the filters as a CDX query-string fragment (each
+// parameter prefixed with "&") to append to any cdx/search/cdx request.
+func (f CDXFilters) QueryString() string {
+	var b strings.Builder
+	if f.From != "" {
+		b.WriteString("&from=" + url.QueryEscape(f.From))
+	}
+	if f.To != "" {
+		b.WriteString("&to=" + url.QueryEscape(f.To))
+	}
+	for _, flt := range statusFilters(f.Status) {
+		b.WriteString("&filter=" + url.QueryEscape(flt))
+	}
+	for _, flt := range mimeFilters(f.Mime) {
+		b.WriteString("&filter=" + url.QueryEscape(flt))
+	}
+	for _, flt := range f.Extra {
+		b.WriteString("&filter=" + url.QueryEscape(flt))
+	}
+	if f.Limit > 0 {
+		b.WriteString("&limit=" + strconv.Itoa(f.Limit))
+	}
+	collapse := f.Collapse
+	if collapse == "" {
+		collapse = "urlkey"
+	}
+	b.WriteString("&collapse=" + url.QueryEscape(collapse))
+	return b.String()
+}
+
+// statusFilters turns a -status value like "200,30x,!404" into CDX
+// filter=statuscode:... clauses: positive codes are OR'd into one regex,
+// each negated code (prefixed with !) becomes its own excluding filter.
+// "x" is treated as a single-digit wildcard, the common shorthand for
+// "30x" meaning any 3xx code.
+func statusFilters(csv string) []string {
+	if strings.TrimSpace(csv) == "" {
+		return nil
+	}
+	var positive []string
+	var filters []string
+	for _, tok := range strings.Split(csv, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" {
+			continue
+		}
+		neg := strings.HasPrefix(tok, "!")
+		tok = strings.TrimPrefix(tok, "!")
+		tok = strings.ReplaceAll(tok, "x", ".")
+		if neg {
+			filters = append(filters, "!statuscode:"+tok)
+		} else {
+			positive = append(positive, tok)
+		}
+	}
+	if len(positive) > 0 {
+		filters = append(filters, "statuscode:("+strings.Join(positive, "|")+")")
+	}
+	return filters
+}
+
+// mimeFilters turns a -mime value like "text/html,application/json" into
+// CDX filter=mimetype:... clauses, with the same "!"-prefix negation
+// convention as statusFilters.
+func mimeFilters(csv string) []string {
+	if strings.TrimSpace(csv) == "" {
+		return nil
+	}
+	var positive []string
+	var filters []string
+	for _, tok := range strings.Split(csv, ",") {
+		tok = strings.TrimSpace(tok)
+		if tok == "" {
+			continue
+		}
+		neg := strings.HasPrefix(tok, "!")
+		tok = strings.TrimPrefix(tok, "!")
+		if neg {
+			filters = append(filters, "!mimetype:"+regexp.QuoteMeta(tok))
+		} else {
+			positive = append(positive, regexp.QuoteMeta(tok))
+		}
+	}
+	if len(positive) > 0 {
+		filters = append(filters, "mimetype:("+strings.Join(positive, "|")+")")
+	}
+	return filters
+}
+
+// repeatedFlag collects every occurrence of a repeatable flag (e.g.
+// -filter urlkey:foo -filter urlkey:bar) into a slice, since flag.String
+// only keeps the last one.
+type repeatedFlag []string
+
+func (r *repeatedFlag) String() string {
+	if r == nil {
+		return ""
+	}
+	return strings.Join(*r, ",")
+}
+
+func (r *repeatedFlag) Set(v string) error {
+	*r = append(*r, v)
+	return nil
+}
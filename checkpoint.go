@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Checkpoint tracks resumable progress for a single -u run: which CDX pages
+// have already been fetched. The seen-set of already-emitted result lines is
+// NOT kept here; callers dedupe against the run's configured Dedupe backend
+// directly, so -resume stays bounded by whatever -dedupe backend the user
+// picked (map, bloom, or disk) rather than rewriting an ever-growing JSON
+// blob to disk every few seconds.
+type Checkpoint struct {
+	Pattern        string       `json:"pattern"`
+	CompletedPages map[int]bool `json:"completed_pages"`
+
+	mu   sync.Mutex
+	path string
+}
+
+// NewCheckpoint creates an empty checkpoint for the given (normalized) URL
+// pattern, bound to path for future saves.
+func NewCheckpoint(pattern, path string) *Checkpoint {
+	return &Checkpoint{
+		Pattern:        pattern,
+		CompletedPages: make(map[int]bool),
+		path:           path,
+	}
+}
+
+// LoadCheckpoint reads a checkpoint file written by Save. It returns
+// (nil, nil) if path does not exist so callers can fall back to a fresh run.
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var c Checkpoint
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return nil, fmt.Errorf("parsing checkpoint %s: %w", path, err)
+	}
+	if c.CompletedPages == nil {
+		c.CompletedPages = make(map[int]bool)
+	}
+	c.path = path
+	return &c, nil
+}
+
+// PageDone reports whether page p has already been completed.
+func (c *Checkpoint) PageDone(p int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.CompletedPages[p]
+}
+
+// MarkPageDone records page p as completed.
+func (c *Checkpoint) MarkPageDone(p int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.CompletedPages[p] = true
+}
+
+// Save writes the checkpoint to its bound path atomically (write to a temp
+// file in the same directory, then rename) so a crash mid-write never
+// corrupts the existing checkpoint.
+func (c *Checkpoint) Save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" {
+		return nil
+	}
+	tmp := c.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("creating temp checkpoint: %w", err)
+	}
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("encoding checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("closing temp checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("renaming checkpoint into place: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"sort"
+)
+
+// Dedupe decides whether a result line has already been emitted. SeenOrAdd
+// reports true the first time a key is seen (the caller should emit it)
+// and false on every call after that.
+type Dedupe interface {
+	SeenOrAdd(key string) bool
+}
+
+// NewDedupe builds the backend selected by -dedupe. file is only used by
+// the "disk" backend; an empty value picks a temp file.
+func NewDedupe(mode, file string) (Dedupe, error) {
+	switch mode {
+	case "", "map":
+		return &MapDedupe{seen: make(map[string]struct{})}, nil
+	case "bloom":
+		return NewScalableBloom(), nil
+	case "disk":
+		return NewDiskDedupe(file)
+	default:
+		return nil, fmt.Errorf("unknown -dedupe backend %q (want map, bloom, or disk)", mode)
+	}
+}
+
+// MapDedupe is the original unbounded in-memory map, kept as the default
+// since it's exact and fast for runs that comfortably fit in RAM.
+type MapDedupe struct {
+	seen map[string]struct{}
+}
+
+func (d *MapDedupe) SeenOrAdd(key string) bool {
+	if _, ok := d.seen[key]; ok {
+		return false
+	}
+	d.seen[key] = struct{}{}
+	return true
+}
+
+// bloomLayer is one fixed-size, fixed-error-rate Bloom filter: an m-bit
+// array tested/set with k independent hash functions derived by
+// double-hashing (Kirsch-Mitzenmacher) two 64-bit FNV-1a digests.
+type bloomLayer struct {
+	bits  []uint64
+	m     uint64
+	k     uint64
+	n     uint64
+	count uint64
+}
+
+func newBloomLayer(n uint64, p float64) *bloomLayer {
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint64(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomLayer{bits: make([]uint64, (m+63)/64), m: m, k: k, n: n}
+}
+
+// halves returns the two independent 64-bit digests used to derive every
+// probe position for key.
+func halves(key string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+	sum1 := h1.Sum64()
+
+	h2 := fnv.New64a()
+	h2.Write([]byte{0x5a}) // salt so sum2 is independent of sum1
+	h2.Write([]byte(key))
+	sum2 := h2.Sum64()
+	if sum2 == 0 {
+		sum2 = 1
+	}
+	return sum1, sum2
+}
+
+func (l *bloomLayer) positions(key string) []uint64 {
+	h1, h2 := halves(key)
+	pos := make([]uint64, l.k)
+	for i := uint64(0); i < l.k; i++ {
+		pos[i] = (h1 + i*h2) % l.m
+	}
+	return pos
+}
+
+func (l *bloomLayer) test(key string) bool {
+	for _, p := range l.positions(key) {
+		if l.bits[p/64]&(1<<(p%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *bloomLayer) add(key string) {
+	for _, p := range l.positions(key) {
+		l.bits[p/64] |= 1 << (p % 64)
+	}
+	l.count++
+}
+
+func (l *bloomLayer) fillRatio() float64 {
+	return float64(l.count) / float64(l.n)
+}
+
+// ScalableBloom is a scalable Bloom filter: it starts with capacity
+// n=1e6 and false-positive rate p=1e-4, and once the newest layer's fill
+// ratio passes 0.5 it appends a fresh layer with double the capacity and
+// a tightened false-positive rate (p*0.8), rather than rehashing what's
+// already been inserted. Membership is the OR of every layer; inserts
+// always go to the newest. Like any Bloom filter it can false-positive
+// (report a URL as seen when it wasn't), trading a small amount of
+// under-counting for memory that grows one fixed-size layer at a time,
+// far less per URL than the exact map.
+type ScalableBloom struct {
+	layers []*bloomLayer
+	nextN  uint64
+	nextP  float64
+}
+
+func NewScalableBloom() *ScalableBloom {
+	b := &ScalableBloom{nextN: 1_000_000, nextP: 1e-4}
+	b.layers = append(b.layers, newBloomLayer(b.nextN, b.nextP))
+	return b
+}
+
+func (b *ScalableBloom) SeenOrAdd(key string) bool {
+	for _, l := range b.layers {
+		if l.test(key) {
+			return false
+		}
+	}
+	newest := b.layers[len(b.layers)-1]
+	if newest.fillRatio() > 0.5 {
+		b.nextN *= 2
+		b.nextP *= 0.8
+		newest = newBloomLayer(b.nextN, b.nextP)
+		b.layers = append(b.layers, newest)
+	}
+	newest.add(key)
+	return true
+}
+
+// DiskDedupe keeps an in-memory index of the most recently seen URL
+// hashes for O(1) lookups against the common case (a just-seen or
+// near-duplicate URL), and spills everything older than that to a sorted
+// on-disk file searched with a binary search over 8-byte big-endian
+// FNV-1a hashes. This bounds memory use for multi-million-URL runs at the
+// cost of an occasional disk seek.
+type DiskDedupe struct {
+	path       string
+	recent     map[uint64]struct{}
+	recentKeys []uint64
+	maxRecent  int
+	pending    []uint64 // novel hashes not yet merged into the sorted file
+	baseCount  int64    // number of sorted entries currently on disk
+}
+
+const diskDedupeFlushThreshold = 200_000
+
+// NewDiskDedupe opens (or creates) the backing file at path, defaulting to
+// a temp file if path is empty.
+func NewDiskDedupe(path string) (*DiskDedupe, error) {
+	if path == "" {
+		path = fmt.Sprintf("%s/mywayback-dedupe-%d.idx", os.TempDir(), os.Getpid())
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening dedupe file: %w", err)
+	}
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("statting dedupe file: %w", err)
+	}
+	return &DiskDedupe{
+		path:      path,
+		recent:    make(map[uint64]struct{}),
+		maxRecent: 1_000_000,
+		baseCount: info.Size() / 8,
+	}, nil
+}
+
+func (d *DiskDedupe) SeenOrAdd(key string) bool {
+	h1, _ := halves(key)
+
+	if _, ok := d.recent[h1]; ok {
+		return false
+	}
+	if found, err := d.searchBase(h1); err == nil && found {
+		return false
+	}
+	if len(d.pending) > d.maxRecent {
+		// Failed flushes let pending outgrow the recent index, so its
+		// oldest hashes can only be found here.
+		for _, h := range d.pending {
+			if h == h1 {
+				return false
+			}
+		}
+	}
+
+	d.remember(h1)
+	d.pending = append(d.pending, h1)
+	if len(d.pending) >= diskDedupeFlushThreshold {
+		_ = d.Flush() // best-effort: a failed flush just means pending grows, never loses correctness
+	}
+	return true
+}
+
+// remember adds hash to the bounded recent-N index, evicting the oldest
+// entry once it's full.
+func (d *DiskDedupe) remember(hash uint64) {
+	if len(d.recentKeys) >= d.maxRecent {
+		oldest := d.recentKeys[0]
+		d.recentKeys = d.recentKeys[1:]
+		delete(d.recent, oldest)
+	}
+	d.recentKeys = append(d.recentKeys, hash)
+	d.recent[hash] = struct{}{}
+}
+
+// searchBase binary-searches the sorted on-disk file for hash without
+// reading it into memory.
+func (d *DiskDedupe) searchBase(hash uint64) (bool, error) {
+	if d.baseCount == 0 {
+		return false, nil
+	}
+	f, err := os.Open(d.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 8)
+	lo, hi := int64(0), d.baseCount-1
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		if _, err := f.ReadAt(buf, mid*8); err != nil {
+			return false, err
+		}
+		v := binary.BigEndian.Uint64(buf)
+		switch {
+		case v == hash:
+			return true, nil
+		case v < hash:
+			lo = mid + 1
+		default:
+			hi = mid - 1
+		}
+	}
+	return false, nil
+}
+
+// Flush merge-sorts the pending (novel, already-deduped) hashes into the
+// existing sorted base file, rewriting it in place via a temp file + rename.
+// Callers that checkpoint progress (e.g. -resume) should call this
+// alongside their own save so a crash doesn't lose hashes only held in
+// d.pending since the last SeenOrAdd-triggered flush.
+func (d *DiskDedupe) Flush() error {
+	if len(d.pending) == 0 {
+		return nil
+	}
+	sort.Slice(d.pending, func(i, j int) bool { return d.pending[i] < d.pending[j] })
+
+	in, err := os.Open(d.path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpPath := d.path + ".tmp"
+	out, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+
+	buf := make([]byte, 8)
+	pi := 0
+	var written int64
+	for {
+		_, rerr := in.Read(buf)
+		if rerr != nil {
+			break
+		}
+		old := binary.BigEndian.Uint64(buf)
+		for pi < len(d.pending) && d.pending[pi] < old {
+			binary.Write(w, binary.BigEndian, d.pending[pi])
+			pi++
+			written++
+		}
+		binary.Write(w, binary.BigEndian, old)
+		written++
+	}
+	for ; pi < len(d.pending); pi++ {
+		binary.Write(w, binary.BigEndian, d.pending[pi])
+		written++
+	}
+
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, d.path); err != nil {
+		return err
+	}
+
+	d.baseCount = written
+	d.pending = d.pending[:0]
+	return nil
+}
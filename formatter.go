@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var defaultStructuredFields = []string{"original", "timestamp", "statuscode", "mimetype", "length", "digest"}
+
+// parseFields resolves -fields into the column list to request from CDX.
+// gron doesn't need extra CDX columns (it decomposes each URL's own query
+// string), so it always just needs the original URL.
+func parseFields(csvFlag, format string) []string {
+	if format == "gron" {
+		return []string{"original"}
+	}
+	if strings.TrimSpace(csvFlag) == "" {
+		return defaultStructuredFields
+	}
+	fields := []string{}
+	for _, f := range strings.Split(csvFlag, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	if len(fields) == 0 {
+		return defaultStructuredFields
+	}
+	return fields
+}
+
+// fieldValue reads the named CDX column off of rec.
+func fieldValue(rec CDXRecord, name string) string {
+	switch name {
+	case "original":
+		return rec.Original
+	case "timestamp":
+		return rec.Timestamp
+	case "statuscode":
+		return rec.StatusCode
+	case "mimetype":
+		return rec.MimeType
+	case "length":
+		return rec.Length
+	case "digest":
+		return rec.Digest
+	default:
+		return ""
+	}
+}
+
+// runFormattedOutput drives -format {jsonl,csv,gron}: it re-queries CDX
+// for the requested fields and serializes each record instead of the bare
+// URL list the plain pipeline emits.
+func runFormattedOutput(client *http.Client, pattern string, pages, pageConcurrency int, format string, fields []string, extRegex *regexp.Regexp, includeMode bool, dedupe Dedupe, outWriter io.Writer, pbar Progress, filters CDXFilters) error {
+	recordsCh := make(chan CDXRecord, 2000)
+	var pagesCompleted int32
+	fl := strings.Join(fields, ",")
+
+	var fetchWg sync.WaitGroup
+	for i := 0; i < pageConcurrency; i++ {
+		fetchWg.Add(1)
+		go func(i int) {
+			defer fetchWg.Done()
+			var pageBytesTotal int64
+			var recsEmitted int64
+			workerStart := time.Now()
+			for p := i; p < pages; p += pageConcurrency {
+				pageURL := "https://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) +
+					"&page=" + strconv.Itoa(p) +
+					"&fl=" + url.QueryEscape(fl) + "&output=json" + filters.QueryString()
+				resp, err := client.Get(pageURL)
+				if err != nil {
+					pbar.Log(fmt.Sprintf("❌ ERROR fetching CDX page %d: %v", p, err), "\033[31m")
+					atomic.AddInt32(&pagesCompleted, 1)
+					pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
+					continue
+				}
+				recs, perr := parseCDXJSON(resp.Body)
+				resp.Body.Close()
+				if perr != nil {
+					pbar.Log(fmt.Sprintf("⚠ WARNING: error parsing CDX page %d: %v", p, perr), "\033[33m")
+				}
+				for _, rec := range recs {
+					recordsCh <- rec
+					pageBytesTotal += int64(len(rec.Original))
+					recsEmitted++
+				}
+				elapsed := time.Since(workerStart).Seconds()
+				recsPerSec := 0.0
+				if elapsed > 0 {
+					recsPerSec = float64(recsEmitted) / elapsed
+				}
+				pbar.UpdateWorker(i, p, pageBytesTotal, recsPerSec, 0)
+				atomic.AddInt32(&pagesCompleted, 1)
+				pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
+			}
+		}(i)
+	}
+	go func() {
+		fetchWg.Wait()
+		close(recordsCh)
+	}()
+
+	bufw := bufio.NewWriter(outWriter)
+	defer bufw.Flush()
+
+	var csvw *csv.Writer
+	if format == "csv" {
+		csvw = csv.NewWriter(bufw)
+		if err := csvw.Write(fields); err != nil {
+			return fmt.Errorf("writing csv header: %w", err)
+		}
+		csvw.Flush()
+	}
+
+	for rec := range recordsCh {
+		if rec.Original == "" {
+			continue
+		}
+		path := rec.Original
+		if u, err := url.Parse(rec.Original); err == nil && u.Path != "" {
+			path = u.Path
+		}
+		if extRegex != nil {
+			match := extRegex.MatchString(path)
+			if includeMode && !match {
+				continue
+			} else if !includeMode && match {
+				continue
+			}
+		}
+
+		switch format {
+		case "jsonl":
+			if !dedupe.SeenOrAdd(rec.Original) {
+				continue
+			}
+			row := make(map[string]string, len(fields))
+			for _, f := range fields {
+				row[f] = fieldValue(rec, f)
+			}
+			b, err := json.Marshal(row)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintln(bufw, string(b))
+			bufw.Flush()
+
+		case "csv":
+			if !dedupe.SeenOrAdd(rec.Original) {
+				continue
+			}
+			vals := make([]string, len(fields))
+			for i, f := range fields {
+				vals[i] = fieldValue(rec, f)
+			}
+			if err := csvw.Write(vals); err != nil {
+				continue
+			}
+			csvw.Flush()
+
+		case "gron":
+			u, err := url.Parse(rec.Original)
+			if err != nil || u.RawQuery == "" {
+				continue
+			}
+			pairs := strings.FieldsFunc(u.RawQuery, func(r rune) bool { return r == '&' || r == ';' })
+			for _, p := range pairs {
+				if p == "" {
+					continue
+				}
+				k, v := p, ""
+				if idx := strings.Index(p, "="); idx >= 0 {
+					k, v = p[:idx], p[idx+1:]
+				}
+				if uk, err := url.QueryUnescape(k); err == nil {
+					k = uk
+				}
+				if uv, err := url.QueryUnescape(v); err == nil {
+					v = uv
+				}
+				line := fmt.Sprintf("%s.q.%s = %q", rec.Original, k, v)
+				if !dedupe.SeenOrAdd(line) {
+					continue
+				}
+				fmt.Fprintln(bufw, line)
+				bufw.Flush()
+			}
+
+		default:
+			return fmt.Errorf("unknown -format %q (want plain, jsonl, csv, or gron)", format)
+		}
+	}
+	return nil
+}
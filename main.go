@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
@@ -30,6 +31,22 @@ func main() {
 	workers := flag.Int("workers", 20, "Number of concurrent processing workers (for URL lines)")
 	pageWorkers := flag.Int("page-workers", 5, "Number of concurrent page fetchers (CDX pages)")
 	timeout := flag.Int("timeout", 80, "HTTP timeout in seconds")
+	resumeFile := flag.String("resume", "", "Checkpoint file to resume from / persist progress to (pair with -o so the prior output can be replayed into the dedupe backend on resume)")
+	fetchWarcDir := flag.String("fetch-warc", "", "Directory to write one WARC file per worker, fetching each archived response body")
+	fetchContent := flag.Bool("fetch-content", false, "Stream each archived response body to the output instead of just the URL")
+	sourcesFlag := flag.String("sources", "wayback", "Comma-separated sources to query: wayback,commoncrawl,otx,urlscan")
+	dedupeMode := flag.String("dedupe", "map", "Dedupe backend: map, bloom, or disk")
+	dedupeFile := flag.String("dedupe-file", "", "Backing file for -dedupe disk (defaults to a temp file)")
+	formatFlag := flag.String("format", "plain", "Output format: plain, jsonl, csv, or gron")
+	fieldsFlag := flag.String("fields", "", "Comma-separated CDX fields to request for -format jsonl/csv (default original,timestamp,statuscode,mimetype,length,digest)")
+	fromFlag := flag.String("from", "", "Only include captures on/after this CDX timestamp (YYYYMMDD or longer)")
+	toFlag := flag.String("to", "", "Only include captures on/before this CDX timestamp (YYYYMMDD or longer)")
+	statusFlag := flag.String("status", "", "Comma-separated status codes to require, e.g. 200,30x,!404")
+	mimeFlag := flag.String("mime", "", "Comma-separated MIME types to require, e.g. text/html,application/json")
+	limitFlag := flag.Int("limit", 0, "Cap the number of CDX rows returned per page (0 = no limit)")
+	collapseFlag := flag.String("collapse", "urlkey", "CDX collapse field, e.g. urlkey or timestamp:8")
+	var filterFlags repeatedFlag
+	flag.Var(&filterFlags, "filter", "Repeatable raw CDX filter in key:regex form, e.g. -filter urlkey:.*login.*")
 	flag.Parse()
 
 	if *urlFlag == "" {
@@ -39,9 +56,119 @@ func main() {
 	}
 
 	client := &http.Client{Timeout: time.Duration(*timeout) * time.Second}
+	pattern := normalizeURL(*urlFlag)
+	cdxFilters := CDXFilters{
+		From:     *fromFlag,
+		To:       *toFlag,
+		Status:   *statusFlag,
+		Mime:     *mimeFlag,
+		Extra:    []string(filterFlags),
+		Limit:    *limitFlag,
+		Collapse: *collapseFlag,
+	}
+
+	sourceNames := parseSources(*sourcesFlag)
+
+	// -resume only drives the classic plain/wayback page-fetcher loop below;
+	// the -sources, -fetch-warc/-fetch-content, and -format pipelines don't
+	// thread checkpoint state through, so refuse the combination instead of
+	// silently reopening the output file in append mode and re-emitting
+	// everything.
+	if *resumeFile != "" {
+		multiSource := len(sourceNames) != 1 || sourceNames[0] != "wayback"
+		if multiSource || *fetchWarcDir != "" || *fetchContent || *formatFlag != "plain" {
+			fmt.Fprintln(os.Stderr, "❌ ERROR: -resume is only supported with the default plain wayback pipeline; it cannot be combined with -sources, -fetch-warc, -fetch-content, or -format")
+			os.Exit(1)
+		}
+	}
+
+	// Dedupe backend shared by every pipeline below. -resume dedupes against
+	// it directly (see the printer goroutine) rather than keeping its own
+	// unbounded in-memory seen-set, so -resume honors whichever -dedupe
+	// backend was chosen.
+	dedupe, err := NewDedupe(*dedupeMode, *dedupeFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ ERROR setting up dedupe backend:", err)
+		os.Exit(1)
+	}
+
+	// Load or create the checkpoint used to resume interrupted runs.
+	var checkpoint *Checkpoint
+	resuming := false
+	if *resumeFile != "" {
+		existing, err := LoadCheckpoint(*resumeFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR loading checkpoint:", err)
+			os.Exit(1)
+		}
+		if existing != nil && existing.Pattern == pattern {
+			checkpoint = existing
+			resuming = true
+			fmt.Fprintf(os.Stderr, "↻ Resuming from checkpoint %s (%d pages already completed)\n", *resumeFile, len(checkpoint.CompletedPages))
+		} else {
+			if existing != nil {
+				fmt.Fprintln(os.Stderr, "⚠ WARNING: checkpoint pattern does not match -u, starting fresh")
+			}
+			checkpoint = NewCheckpoint(pattern, *resumeFile)
+		}
+	}
+
+	// Compile extension filters
+	extRegex, includeMode, err := CompileExtRegex(*includeExt, *excludeExt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "❌ ERROR compiling extension regex:", err)
+		os.Exit(1)
+	}
+
+	// Prepare output writer
+	var outFile *os.File
+	var outWriter io.Writer = os.Stdout
+	if *outputFile != "" {
+		var f *os.File
+		var err error
+		if resuming {
+			// Replay whatever the prior run already wrote through dedupe
+			// before appending, since CDX only collapses duplicates within a
+			// single page and a resumed run can otherwise re-emit URLs the
+			// prior run already wrote.
+			if rf, rerr := os.Open(*outputFile); rerr == nil {
+				replayDedupe(rf, dedupe)
+				rf.Close()
+			} else if !os.IsNotExist(rerr) {
+				fmt.Fprintln(os.Stderr, "⚠ WARNING: could not reopen output file to replay dedupe state:", rerr)
+			}
+			f, err = os.OpenFile(*outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		} else {
+			f, err = os.Create(*outputFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR creating output file:", err)
+			os.Exit(1)
+		}
+		outFile = f
+		outWriter = io.MultiWriter(os.Stdout, outFile)
+	} else if resuming {
+		fmt.Fprintln(os.Stderr, "⚠ WARNING: -resume without -o cannot replay prior output into the dedupe backend; duplicates across the resume boundary are possible")
+	}
+
+	if len(sourceNames) != 1 || sourceNames[0] != "wayback" {
+		sources, err := NewSources(sourceNames, client, cdxFilters)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR:", err)
+			os.Exit(1)
+		}
+		if err := runMultiSource(sources, pattern, *workers, extRegex, includeMode, *onlyQuery, *onlyQueryKeys, *noQuery, outWriter, dedupe); err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR:", err)
+			os.Exit(1)
+		}
+		if outFile != nil {
+			outFile.Close()
+		}
+		return
+	}
 
 	// 1) Request number of pages
-	pagesURL := "http://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(normalizeURL(*urlFlag)) + "&showNumPages=true"
+	pagesURL := "http://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) + "&showNumPages=true" + cdxFilters.QueryString()
 	resp, err := client.Get(pagesURL)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "❌ ERROR fetching page count from CDX:", err)
@@ -74,39 +201,55 @@ func main() {
 		}
 	}
 
-	// Compile extension filters
-	extRegex, includeMode, err := CompileExtRegex(*includeExt, *excludeExt)
-	if err != nil {
-		fmt.Fprintln(os.Stderr, "❌ ERROR compiling extension regex:", err)
-		os.Exit(1)
+	if pages == 0 {
+		// nothing to fetch; exit gracefully
+		fmt.Fprintln(os.Stderr, "No pages reported by CDX; nothing to do.")
+		if outFile != nil {
+			outFile.Close()
+		}
+		return
 	}
 
-	// Prepare output writer
-	var outFile *os.File
-	var outWriter io.Writer = os.Stdout
-	if *outputFile != "" {
-		f, err := os.Create(*outputFile)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "❌ ERROR creating output file:", err)
+	// Determine page-fetcher concurrency up front so the progress pool can
+	// be sized to match.
+	pageConcurrency := *pageWorkers
+	if pageConcurrency < 1 {
+		pageConcurrency = 1
+	}
+	if pages < pageConcurrency {
+		pageConcurrency = pages
+	}
+
+	// Create progress bar: one line per page-worker plus an aggregate total.
+	pool := NewPBarPool(pageConcurrency, pages)
+	var pbar Progress = pool
+	pbar.Render(0)
+
+	if *fetchWarcDir != "" || *fetchContent {
+		if err := fetchWarcMode(client, pattern, pages, *pageWorkers, *workers, *fetchWarcDir, *fetchContent, outWriter, pbar, cdxFilters); err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR in fetch-warc mode:", err)
 			os.Exit(1)
 		}
-		outFile = f
-		outWriter = io.MultiWriter(os.Stdout, outFile)
+		if outFile != nil {
+			outFile.Close()
+		}
+		pbar.Finish()
+		return
 	}
 
-	if pages == 0 {
-		// nothing to fetch; exit gracefully
-		fmt.Fprintln(os.Stderr, "No pages reported by CDX; nothing to do.")
+	if *formatFlag != "plain" {
+		fields := parseFields(*fieldsFlag, *formatFlag)
+		if err := runFormattedOutput(client, pattern, pages, pageConcurrency, *formatFlag, fields, extRegex, includeMode, dedupe, outWriter, pbar, cdxFilters); err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR in", *formatFlag, "mode:", err)
+			os.Exit(1)
+		}
 		if outFile != nil {
 			outFile.Close()
 		}
+		pbar.Finish()
 		return
 	}
 
-	// Create progress bar
-	pbar := NewPBar(pages)
-	pbar.Render(0)
-
 	// Channels
 	pageJobs := make(chan int, *pageWorkers)
 	jobs := make(chan string, 2000)
@@ -116,27 +259,24 @@ func main() {
 
 	// Page fetchers
 	var fetchWg sync.WaitGroup
-	pageConcurrency := *pageWorkers
-	if pageConcurrency < 1 {
-		pageConcurrency = 1
-	}
-	if pages < pageConcurrency {
-		pageConcurrency = pages
-	}
 	maxRetries := 3
 	for i := 0; i < pageConcurrency; i++ {
 		fetchWg.Add(1)
-		go func() {
+		go func(workerIdx int) {
 			defer fetchWg.Done()
+			var urlsEmitted int64
+			workerStart := time.Now()
 			for p := range pageJobs {
-				pageURL := "https://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(normalizeURL(*urlFlag)) + "&page=" + strconv.Itoa(p) + "&fl=original&collapse=urlkey"
+				pageURL := "https://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) + "&page=" + strconv.Itoa(p) + "&fl=original" + cdxFilters.QueryString()
 				var respP *http.Response
 				var ierr error
+				retries := 0
 				for attempt := 1; attempt <= maxRetries; attempt++ {
 					respP, ierr = client.Get(pageURL)
 					if ierr == nil && respP != nil && respP.StatusCode >= 200 && respP.StatusCode < 300 {
 						break
 					}
+					retries++
 					if respP != nil {
 						respP.Body.Close()
 					}
@@ -155,11 +295,14 @@ func main() {
 					continue
 				}
 
+				var pageBytes int64
 				sc := bufio.NewScanner(respP.Body)
 				for sc.Scan() {
 					line := strings.TrimSpace(sc.Text())
 					if line != "" {
 						jobs <- line
+						pageBytes += int64(len(line))
+						urlsEmitted++
 					}
 				}
 				if err := sc.Err(); err != nil {
@@ -167,11 +310,20 @@ func main() {
 					pbar.Log(msg, "\033[33m")
 					pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
 				}
+				elapsed := time.Since(workerStart).Seconds()
+				urlsPerSec := 0.0
+				if elapsed > 0 {
+					urlsPerSec = float64(urlsEmitted) / elapsed
+				}
+				pool.UpdateWorker(workerIdx, p, pageBytes, urlsPerSec, retries)
 				respP.Body.Close()
 				atomic.AddInt32(&pagesCompleted, 1)
+				if checkpoint != nil {
+					checkpoint.MarkPageDone(p)
+				}
 				pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
 			}
-		}()
+		}(i)
 	}
 
 	// Processing workers
@@ -248,13 +400,11 @@ func main() {
 	printWg.Add(1)
 	go func() {
 		defer printWg.Done()
-		seen := make(map[string]struct{})
 		bufw := bufio.NewWriter(outWriter)
 		for r := range resultsCh {
-			if _, ok := seen[r]; ok {
+			if !dedupe.SeenOrAdd(r) {
 				continue
 			}
-			seen[r] = struct{}{}
 			// clear progress bar, print the data line, flush and redraw bar
 			pbar.ClearLine()
 			fmt.Fprintln(bufw, r)
@@ -268,8 +418,51 @@ func main() {
 		}
 	}()
 
-	// Dispatch page numbers
+	// Flush the checkpoint to disk on SIGINT so an interrupted run can be
+	// resumed later with -resume.
+	if checkpoint != nil {
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		go func() {
+			<-sigCh
+			pbar.Log("⚠ interrupted, saving checkpoint...", "\033[33m")
+			if err := checkpoint.Save(); err != nil {
+				fmt.Fprintln(os.Stderr, "❌ ERROR saving checkpoint:", err)
+			}
+			flushDedupe(dedupe)
+			if outFile != nil {
+				outFile.Close()
+			}
+			pbar.Finish()
+			os.Exit(130)
+		}()
+
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-ticker.C:
+					if err := checkpoint.Save(); err != nil {
+						pbar.Log(fmt.Sprintf("⚠ WARNING: checkpoint save failed: %v", err), "\033[33m")
+					}
+					flushDedupe(dedupe)
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	// Dispatch page numbers, skipping any already completed in a prior run.
 	for p := 0; p < pages; p++ {
+		if checkpoint != nil && checkpoint.PageDone(p) {
+			atomic.AddInt32(&pagesCompleted, 1)
+			pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
+			continue
+		}
 		pageJobs <- p
 	}
 	close(pageJobs)
@@ -285,10 +478,36 @@ func main() {
 	close(resultsCh)
 	printWg.Wait()
 
+	if checkpoint != nil {
+		if err := checkpoint.Save(); err != nil {
+			fmt.Fprintln(os.Stderr, "❌ ERROR saving final checkpoint:", err)
+		}
+		flushDedupe(dedupe)
+	}
+
 	// finish progress bar line
 	pbar.Finish()
 }
 
+// flushDedupe persists dedupe's pending state to disk if its backend
+// supports it (only DiskDedupe does), so an interrupted -resume run doesn't
+// lose hashes seen since the backend's own flush threshold last tripped.
+func flushDedupe(dedupe Dedupe) {
+	if f, ok := dedupe.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+}
+
+// replayDedupe feeds every line already in r through dedupe so a resumed
+// run treats them as already emitted instead of writing them again.
+func replayDedupe(r io.Reader, dedupe Dedupe) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		dedupe.SeenOrAdd(sc.Text())
+	}
+}
+
 // normalizeURL ensures the pattern ends with * if missing
 func normalizeURL(u string) string {
 	u = strings.TrimSpace(u)
@@ -3,116 +3,132 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 	"sync"
 	"time"
 )
 
-// Simple TTY-aware progress bar. When a tty (/dev/tty) is available the bar
-// is rendered there so stdout remains safe to pipe. If no tty is available
-// (for example when stdout is redirected), rendering is disabled and Log will
-// instead write colored messages to stderr so they don't mix with piped data.
-type PBar struct {
-	Total       int
-	Width       int
-	DoneStr     string
-	OngoingStr  string
-	mu          sync.Mutex
-	out         *os.File // /dev/tty when available, otherwise nil (disabled)
-	start       time.Time
-	status      string
-	statusColor string
+// Progress is the rendering API every pipeline drives its progress output
+// through, letting callers like fetchWarcMode stay agnostic to which
+// rendering style (currently just PBarPool) is in use.
+type Progress interface {
+	Render(curr int)
+	ClearLine()
+	Log(msg, color string)
+	Finish()
+	UpdateWorker(idx int, page int, bytes int64, urlsPerSec float64, retries int)
 }
 
-func NewPBar(total int) *PBar {
-	p := &PBar{
-		Total:      total,
-		Width:      40,
-		DoneStr:    "#",
-		OngoingStr: ".",
-		start:      time.Now(),
+// workerStatus is one page-worker's latest reported state, rendered as its
+// own line by PBarPool.
+type workerStatus struct {
+	page       int
+	bytes      int64
+	urlsPerSec float64
+	retries    int
+	active     bool
+}
+
+// PBarPool renders one line per active page-worker plus a trailing "Total"
+// line, redrawing the whole block in place with ANSI cursor-up, similar to
+// the cheggaaa/pb pool style. When no /dev/tty is available it falls back
+// to periodic one-line summaries on stderr so piped stdout stays clean.
+type PBarPool struct {
+	mu       sync.Mutex
+	out      *os.File
+	workers  []workerStatus
+	total    int
+	lastCurr int
+	start    time.Time
+
+	linesDrawn   int
+	lastFallback time.Time
+}
+
+// NewPBarPool creates a pool sized for numWorkers page-workers tracking
+// progress against total pages.
+func NewPBarPool(numWorkers, total int) *PBarPool {
+	p := &PBarPool{
+		workers: make([]workerStatus, numWorkers),
+		total:   total,
+		start:   time.Now(),
 	}
-	// Prefer writing to /dev/tty so stdout remains pipable. If we can't open
-	// /dev/tty then rendering is disabled (out == nil) to avoid interfering
-	// with piped stdout.
 	if tty, err := os.OpenFile("/dev/tty", os.O_WRONLY, 0); err == nil {
 		p.out = tty
-	} else {
-		p.out = nil
 	}
 	return p
 }
 
-// Render updates the progress bar to the given current value. If no TTY is
-// available Render is a no-op.
-func (p *PBar) Render(curr int) {
+// UpdateWorker records worker idx's latest status and redraws the pool.
+func (p *PBarPool) UpdateWorker(idx int, page int, bytes int64, urlsPerSec float64, retries int) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.out == nil {
-		return
+	if idx >= 0 && idx < len(p.workers) {
+		p.workers[idx] = workerStatus{page: page, bytes: bytes, urlsPerSec: urlsPerSec, retries: retries, active: true}
 	}
-	if p.Total <= 0 {
-		fmt.Fprintf(p.out, "\rProgress: %d/%d", curr, p.Total)
-		return
-	}
-	if curr > p.Total {
-		curr = p.Total
-	}
-	done := int(float64(curr) * float64(p.Width) / float64(p.Total))
-	if done > p.Width {
-		done = p.Width
-	}
-	// old combined bar string removed; we build colored parts separately below
+	p.mu.Unlock()
+	p.draw(-1)
+}
 
-	// Colorize bar: done part green, remaining part dim
-	green := "\033[32m"
-	dim := "\033[90m"
-	reset := "\033[0m"
-	donePart := strings.Repeat(p.DoneStr, done)
-	remPart := strings.Repeat(p.OngoingStr, p.Width-done)
-	coloredBar := fmt.Sprintf("%s%s%s%s%s", green, donePart, reset, dim, remPart)
+// Render updates the aggregate "Total" line to curr/Total pages completed.
+func (p *PBarPool) Render(curr int) {
+	p.draw(curr)
+}
+
+func (p *PBarPool) draw(curr int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if curr >= 0 {
+		p.lastCurr = curr
+	}
 
-	// append status in brackets (trim if too long)
-	status := p.status
-	if status != "" {
-		maxStatus := 60
-		if len(status) > maxStatus {
-			status = status[:maxStatus-3] + "..."
+	if p.out == nil {
+		if time.Since(p.lastFallback) < time.Second {
+			return
 		}
+		p.lastFallback = time.Now()
+		fmt.Fprintf(os.Stderr, "Total: %d/%d pages\n", p.lastCurr, p.total)
+		return
 	}
 
-	// Format: [<bar>] curr/total (X.X%) [STATUS]
-	percent := float64(curr) / float64(p.Total) * 100
-	if status != "" {
-		if p.statusColor != "" {
-			fmt.Fprintf(p.out, "\r[%s] %d/%d (%.1f%%) [%s%s%s]", coloredBar, curr, p.Total, percent, p.statusColor, status, reset)
+	if p.linesDrawn > 0 {
+		fmt.Fprintf(p.out, "\033[%dA", p.linesDrawn)
+	}
+	lines := 0
+	for i, w := range p.workers {
+		if !w.active {
+			fmt.Fprintf(p.out, "\r\033[Kworker %d: idle\n", i)
 		} else {
-			fmt.Fprintf(p.out, "\r[%s] %d/%d (%.1f%%) [%s]", coloredBar, curr, p.Total, percent, status)
+			fmt.Fprintf(p.out, "\r\033[Kworker %d: page %d, %d bytes, %.1f urls/s, %d retries\n", i, w.page, w.bytes, w.urlsPerSec, w.retries)
 		}
-	} else {
-		fmt.Fprintf(p.out, "\r[%s] %d/%d (%.1f%%)", coloredBar, curr, p.Total, percent)
+		lines++
+	}
+	percent := 0.0
+	if p.total > 0 {
+		percent = float64(p.lastCurr) / float64(p.total) * 100
 	}
+	fmt.Fprintf(p.out, "\r\033[KTotal: %d/%d pages (%.1f%%)\n", p.lastCurr, p.total, percent)
+	lines++
+	p.linesDrawn = lines
 }
 
-// ClearLine erases the current progress line so other output can be printed.
-func (p *PBar) ClearLine() {
+// ClearLine erases the rendered block so other output (a result line) can
+// be printed above it.
+func (p *PBarPool) ClearLine() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	if p.out == nil {
+	if p.out == nil || p.linesDrawn == 0 {
 		return
 	}
-	// ANSI escape to clear line
-	fmt.Fprint(p.out, "\r\033[K")
+	fmt.Fprintf(p.out, "\033[%dA", p.linesDrawn)
+	for i := 0; i < p.linesDrawn; i++ {
+		fmt.Fprint(p.out, "\r\033[K\n")
+	}
+	fmt.Fprintf(p.out, "\033[%dA", p.linesDrawn)
+	p.linesDrawn = 0
 }
 
-// Log sets a short status message that will be shown after the progress bar.
-// If no TTY is available it falls back to printing the colored message to
-// stderr so piped stdout is not interrupted.
-func (p *PBar) Log(msg string, color string) {
-	p.mu.Lock()
-	p.status = msg
-	p.statusColor = color
-	p.mu.Unlock()
+// Log prints a one-off status message above the pool, falling back to
+// stderr when there is no tty.
+func (p *PBarPool) Log(msg string, color string) {
 	if p.out == nil {
 		reset := "\033[0m"
 		if color == "" {
@@ -122,25 +138,25 @@ func (p *PBar) Log(msg string, color string) {
 		}
 		return
 	}
-	// re-render to show updated status
-	// Note: Render locks internally so it's safe to call here
-	p.Render(0)
+	p.ClearLine()
+	reset := "\033[0m"
+	if color != "" {
+		fmt.Fprintln(p.out, color+msg+reset)
+	} else {
+		fmt.Fprintln(p.out, msg)
+	}
+	p.draw(-1)
 }
 
-// Finish moves to the next line (call when done) and closes /dev/tty if we
-// opened it.
-func (p *PBar) Finish() {
+// Finish redraws the block one last time and releases /dev/tty.
+func (p *PBarPool) Finish() {
+	p.draw(-1)
 	p.mu.Lock()
-	defer p.mu.Unlock()
-	if p.out == nil {
-		return
-	}
-	fmt.Fprintln(p.out, "")
-	// close if this is a file other than standard streams
-	if p.out != nil {
-		// best-effort close; ignore error
-		_ = p.out.Close()
-		p.out = nil
+	out := p.out
+	p.out = nil
+	p.mu.Unlock()
+	if out != nil {
+		_ = out.Close()
 	}
 }
 
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Record is a single historic URL surfaced by a Source.
+type Record struct {
+	URL string
+}
+
+// Source enumerates historic URLs matching pattern from one backend.
+// Fetch streams results as they arrive rather than buffering them, since a
+// single query can return millions of rows.
+type Source interface {
+	Name() string
+	Fetch(ctx context.Context, pattern string) (<-chan Record, error)
+}
+
+// parseSources splits a -sources flag value into a normalized, deduped
+// list of lowercase names, defaulting to "wayback" if csv is blank.
+func parseSources(csv string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, p := range strings.Split(csv, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == "" || seen[p] {
+			continue
+		}
+		seen[p] = true
+		names = append(names, p)
+	}
+	if len(names) == 0 {
+		names = []string{"wayback"}
+	}
+	return names
+}
+
+// NewSources resolves a list of source names (as produced by parseSources)
+// into Source implementations sharing client.
+func NewSources(names []string, client *http.Client, filters CDXFilters) ([]Source, error) {
+	sources := make([]Source, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "wayback":
+			sources = append(sources, &WaybackSource{client: client, filters: filters})
+		case "commoncrawl":
+			sources = append(sources, &CommonCrawlSource{client: client, filters: filters})
+		case "otx":
+			sources = append(sources, &OTXSource{client: client})
+		case "urlscan":
+			sources = append(sources, &URLScanSource{client: client})
+		default:
+			return nil, fmt.Errorf("unknown source %q (want wayback, commoncrawl, otx, or urlscan)", name)
+		}
+	}
+	return sources, nil
+}
+
+// runMultiSource fans results in from every source, runs them through the
+// same extension/query filters as the single-source pipeline, dedupes, and
+// writes them to outWriter.
+func runMultiSource(sources []Source, pattern string, workers int, extRegex *regexp.Regexp, includeMode, onlyQuery, onlyQueryKeys, noQuery bool, outWriter io.Writer, dedupe Dedupe) error {
+	ctx := context.Background()
+
+	recordsCh := make(chan Record, 2000)
+	var fetchWg sync.WaitGroup
+	for _, src := range sources {
+		fetchWg.Add(1)
+		go func(s Source) {
+			defer fetchWg.Done()
+			ch, err := s.Fetch(ctx, pattern)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "❌ ERROR querying %s: %v\n", s.Name(), err)
+				return
+			}
+			for r := range ch {
+				recordsCh <- r
+			}
+		}(src)
+	}
+	go func() {
+		fetchWg.Wait()
+		close(recordsCh)
+	}()
+
+	resultsCh := make(chan string, 2000)
+	var workerWg sync.WaitGroup
+	if workers < 1 {
+		workers = 1
+	}
+	for i := 0; i < workers; i++ {
+		workerWg.Add(1)
+		go func() {
+			defer workerWg.Done()
+			for rec := range recordsCh {
+				line := strings.TrimSpace(rec.URL)
+				if line == "" {
+					continue
+				}
+
+				u, err := url.Parse(line)
+				path := line
+				if err == nil && u.Path != "" {
+					path = u.Path
+				}
+
+				if extRegex != nil {
+					match := extRegex.MatchString(path)
+					if includeMode && !match {
+						continue
+					} else if !includeMode && match {
+						continue
+					}
+				}
+
+				if onlyQuery {
+					if err == nil && u.RawQuery != "" {
+						resultsCh <- u.RawQuery
+					}
+					continue
+				}
+				if onlyQueryKeys {
+					if err == nil && u.RawQuery != "" {
+						pairs := strings.FieldsFunc(u.RawQuery, func(r rune) bool { return r == '&' || r == ';' })
+						for _, p := range pairs {
+							if p == "" {
+								continue
+							}
+							k := p
+							if idx := strings.Index(p, "="); idx >= 0 {
+								k = p[:idx]
+							}
+							if k == "" {
+								continue
+							}
+							if un, err := url.QueryUnescape(k); err == nil {
+								k = un
+							}
+							resultsCh <- k
+						}
+					}
+					continue
+				}
+				if noQuery && err == nil {
+					u.RawQuery = ""
+					resultsCh <- u.String()
+					continue
+				}
+
+				resultsCh <- line
+			}
+		}()
+	}
+	go func() {
+		workerWg.Wait()
+		close(resultsCh)
+	}()
+
+	bufw := bufio.NewWriter(outWriter)
+	count := 0
+	for r := range resultsCh {
+		if !dedupe.SeenOrAdd(r) {
+			continue
+		}
+		fmt.Fprintln(bufw, r)
+		count++
+		if count%500 == 0 {
+			bufw.Flush()
+			fmt.Fprintf(os.Stderr, "\r%d URLs so far...", count)
+		}
+	}
+	bufw.Flush()
+	fmt.Fprintf(os.Stderr, "\r✔ %d URLs total\n", count)
+	return nil
+}
+
+// WaybackSource queries the Wayback Machine CDX API, the original and
+// still-default source.
+type WaybackSource struct {
+	client  *http.Client
+	filters CDXFilters
+}
+
+func (s *WaybackSource) Name() string { return "wayback" }
+
+func (s *WaybackSource) Fetch(ctx context.Context, pattern string) (<-chan Record, error) {
+	pagesURL := "http://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) + "&showNumPages=true" + s.filters.QueryString()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pagesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching page count: %w", err)
+	}
+	numStr := ""
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" {
+			numStr = line
+			break
+		}
+	}
+	resp.Body.Close()
+	pages, _ := strconv.Atoi(numStr)
+	if pages == 0 {
+		pages = 1
+	}
+
+	out := make(chan Record, 2000)
+	go func() {
+		defer close(out)
+		for p := 0; p < pages; p++ {
+			pageURL := "https://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) + "&page=" + strconv.Itoa(p) + "&fl=original" + s.filters.QueryString()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, pageURL, nil)
+			if err != nil {
+				continue
+			}
+			resp, err := s.client.Do(req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: wayback page %d: %v\n", p, err)
+				continue
+			}
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				line := strings.TrimSpace(sc.Text())
+				if line != "" {
+					out <- Record{URL: line}
+				}
+			}
+			resp.Body.Close()
+		}
+	}()
+	return out, nil
+}
+
+// CommonCrawlSource queries every currently published Common Crawl index,
+// auto-discovered from collinfo.json, in place of hardcoding a CC-MAIN id
+// that will eventually go stale.
+type CommonCrawlSource struct {
+	client  *http.Client
+	filters CDXFilters
+}
+
+func (s *CommonCrawlSource) Name() string { return "commoncrawl" }
+
+type ccIndex struct {
+	ID     string `json:"id"`
+	Name   string `json:"name"`
+	CdxAPI string `json:"cdx-api"`
+}
+
+func (s *CommonCrawlSource) Fetch(ctx context.Context, pattern string) (<-chan Record, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://index.commoncrawl.org/collinfo.json", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching collinfo.json: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var indexes []ccIndex
+	if err := json.NewDecoder(resp.Body).Decode(&indexes); err != nil {
+		return nil, fmt.Errorf("parsing collinfo.json: %w", err)
+	}
+
+	out := make(chan Record, 2000)
+	go func() {
+		defer close(out)
+		for _, idx := range indexes {
+			if idx.CdxAPI == "" {
+				continue
+			}
+			q := idx.CdxAPI + "?url=" + url.QueryEscape(pattern) + "&output=json&fl=url" + s.filters.QueryString()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, q, nil)
+			if err != nil {
+				continue
+			}
+			resp, err := s.client.Do(req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: common crawl index %s: %v\n", idx.ID, err)
+				continue
+			}
+			sc := bufio.NewScanner(resp.Body)
+			for sc.Scan() {
+				line := strings.TrimSpace(sc.Text())
+				if line == "" {
+					continue
+				}
+				var row map[string]string
+				if err := json.Unmarshal([]byte(line), &row); err != nil {
+					continue
+				}
+				if u := row["url"]; u != "" {
+					out <- Record{URL: u}
+				}
+			}
+			resp.Body.Close()
+		}
+	}()
+	return out, nil
+}
+
+// OTXSource queries AlienVault OTX's passive-DNS-derived URL list for a
+// hostname.
+type OTXSource struct {
+	client *http.Client
+}
+
+func (s *OTXSource) Name() string { return "otx" }
+
+type otxURLList struct {
+	URLList []struct {
+		URL string `json:"url"`
+	} `json:"url_list"`
+	HasNext bool `json:"has_next"`
+}
+
+func (s *OTXSource) Fetch(ctx context.Context, pattern string) (<-chan Record, error) {
+	host := hostFromPattern(pattern)
+	out := make(chan Record, 2000)
+	go func() {
+		defer close(out)
+		for page := 1; ; page++ {
+			q := "https://otx.alienvault.com/api/v1/indicators/hostname/" + url.PathEscape(host) + "/url_list?limit=100&page=" + strconv.Itoa(page)
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, q, nil)
+			if err != nil {
+				return
+			}
+			resp, err := s.client.Do(req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: otx page %d: %v\n", page, err)
+				return
+			}
+			var list otxURLList
+			err = json.NewDecoder(resp.Body).Decode(&list)
+			resp.Body.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: otx page %d: %v\n", page, err)
+				return
+			}
+			for _, u := range list.URLList {
+				if u.URL != "" {
+					out <- Record{URL: u.URL}
+				}
+			}
+			if !list.HasNext || len(list.URLList) == 0 {
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+// URLScanSource queries urlscan.io's public search API for URLs scanned
+// under a hostname.
+type URLScanSource struct {
+	client *http.Client
+}
+
+func (s *URLScanSource) Name() string { return "urlscan" }
+
+type urlscanSearchResult struct {
+	Results []struct {
+		Page struct {
+			URL string `json:"url"`
+		} `json:"page"`
+		Sort []interface{} `json:"sort"`
+	} `json:"results"`
+	HasMore bool `json:"has_more"`
+}
+
+func (s *URLScanSource) Fetch(ctx context.Context, pattern string) (<-chan Record, error) {
+	host := hostFromPattern(pattern)
+	out := make(chan Record, 2000)
+	go func() {
+		defer close(out)
+		searchAfter := ""
+		for {
+			q := "https://urlscan.io/api/v1/search/?q=domain:" + url.QueryEscape(host)
+			if searchAfter != "" {
+				q += "&search_after=" + url.QueryEscape(searchAfter)
+			}
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, q, nil)
+			if err != nil {
+				return
+			}
+			resp, err := s.client.Do(req)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: urlscan: %v\n", err)
+				return
+			}
+			var result urlscanSearchResult
+			err = json.NewDecoder(resp.Body).Decode(&result)
+			resp.Body.Close()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "⚠ WARNING: urlscan: %v\n", err)
+				return
+			}
+			if len(result.Results) == 0 {
+				return
+			}
+			for _, r := range result.Results {
+				if r.Page.URL != "" {
+					out <- Record{URL: r.Page.URL}
+				}
+			}
+			if !result.HasMore {
+				return
+			}
+			// urlscan pages via search_after: the sort key of the last hit on
+			// this page, comma-joined, fed back as the next request's cursor.
+			last := result.Results[len(result.Results)-1].Sort
+			if len(last) == 0 {
+				return
+			}
+			parts := make([]string, len(last))
+			for i, v := range last {
+				parts[i] = fmt.Sprint(v)
+			}
+			searchAfter = strings.Join(parts, ",")
+		}
+	}()
+	return out, nil
+}
+
+// hostFromPattern strips the leading/trailing wildcard from a normalized
+// -u pattern (e.g. "*.example.com*" -> "example.com") for APIs that take a
+// bare hostname instead of a CDX-style match pattern.
+func hostFromPattern(pattern string) string {
+	h := strings.TrimSuffix(pattern, "*")
+	h = strings.TrimPrefix(h, "*.")
+	h = strings.TrimPrefix(h, "*")
+	if i := strings.IndexAny(h, "/?#"); i >= 0 {
+		h = h[:i]
+	}
+	return h
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CDXRecord is one row of an extended CDX query requested with
+// &fl=original,timestamp,statuscode,mimetype,length,digest&output=json.
+type CDXRecord struct {
+	Original   string
+	Timestamp  string
+	StatusCode string
+	MimeType   string
+	Length     string
+	Digest     string
+}
+
+// ArchiveURL returns the URL that serves the raw archived response body for
+// this record. The `id_` modifier suppresses Wayback's link rewriting so
+// the bytes returned match what was originally captured.
+func (r CDXRecord) ArchiveURL() string {
+	return "https://web.archive.org/web/" + r.Timestamp + "id_/" + r.Original
+}
+
+// parseCDXJSON decodes a CDX `output=json` response body: a JSON array of
+// arrays where the first row is the field-name header and every row after
+// it is a data row in that column order.
+func parseCDXJSON(body io.Reader) ([]CDXRecord, error) {
+	var rows [][]string
+	if err := json.NewDecoder(body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	if len(rows) < 2 {
+		return nil, nil
+	}
+	col := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	records := make([]CDXRecord, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		records = append(records, CDXRecord{
+			Original:   field(row, "original"),
+			Timestamp:  field(row, "timestamp"),
+			StatusCode: field(row, "statuscode"),
+			MimeType:   field(row, "mimetype"),
+			Length:     field(row, "length"),
+			Digest:     field(row, "digest"),
+		})
+	}
+	return records, nil
+}
+
+// WarcWriter appends WARC 1.1 records to a single WARC file using
+// gzip-per-record framing (each record is its own gzip member), the
+// convention used by the Internet Archive's own WARC files.
+type WarcWriter struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewWarcWriter creates path (truncating if it exists) and writes the
+// leading warcinfo record describing this crawl.
+func NewWarcWriter(path, pattern string) (*WarcWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &WarcWriter{f: f}
+	info := "software: mywayback\r\nformat: WARC File Format 1.1\r\nfetch-pattern: " + pattern + "\r\n"
+	if err := w.writeRecord("warcinfo", newWarcID(), map[string]string{
+		"Content-Type": "application/warc-fields",
+		"WARC-Date":    time.Now().UTC().Format(time.RFC3339),
+	}, []byte(info)); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// WriteResponse appends a request/response record pair for a fetched CDX
+// record and returns the response record's WARC-Record-ID, used to point
+// later `revisit` records back at this capture.
+func (w *WarcWriter) WriteResponse(rec CDXRecord, body []byte) (string, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	date := warcDate(rec.Timestamp)
+	reqID := newWarcID()
+	reqLine := syntheticRequest(rec.Original)
+	if err := w.writeRecord("request", reqID, map[string]string{
+		"Content-Type":    "application/http; msgtype=request",
+		"WARC-Target-URI": rec.Original,
+		"WARC-Date":       date,
+	}, reqLine); err != nil {
+		return "", err
+	}
+
+	respID := newWarcID()
+	headers := map[string]string{
+		"Content-Type":        "application/http; msgtype=response",
+		"WARC-Target-URI":     rec.Original,
+		"WARC-Concurrent-To":  reqID,
+		"WARC-Payload-Digest": "sha1:" + sha1Base32(body),
+		"WARC-Date":           date,
+	}
+	if err := w.writeRecord("response", respID, headers, syntheticResponse(rec, body)); err != nil {
+		return "", err
+	}
+	return respID, nil
+}
+
+// WriteRevisit appends a `revisit` record in place of re-downloading a CDX
+// record whose payload digest matches one already written, refersTo being
+// the WARC-Record-ID of that earlier response record.
+func (w *WarcWriter) WriteRevisit(rec CDXRecord, refersTo string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	headers := map[string]string{
+		"Content-Type":        "application/http; msgtype=response",
+		"WARC-Target-URI":     rec.Original,
+		"WARC-Profile":        "http://netpreserve.org/warc/1.1/revisit/identical-payload-digest",
+		"WARC-Refers-To":      refersTo,
+		"WARC-Payload-Digest": "sha1:" + rec.Digest,
+		"WARC-Date":           warcDate(rec.Timestamp),
+	}
+	return w.writeRecord("revisit", newWarcID(), headers, nil)
+}
+
+// Close flushes and closes the underlying file.
+func (w *WarcWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+// writeRecord gzip-compresses a single WARC record as its own gzip member
+// and appends it to the file.
+func (w *WarcWriter) writeRecord(recordType, id string, headers map[string]string, body []byte) error {
+	var buf strings.Builder
+	buf.WriteString("WARC/1.1\r\n")
+	fmt.Fprintf(&buf, "WARC-Type: %s\r\n", recordType)
+	fmt.Fprintf(&buf, "WARC-Record-ID: %s\r\n", id)
+	for _, k := range []string{"WARC-Date", "WARC-Target-URI", "WARC-Concurrent-To", "WARC-Refers-To", "WARC-Profile", "WARC-Payload-Digest", "Content-Type"} {
+		if v, ok := headers[k]; ok {
+			fmt.Fprintf(&buf, "%s: %s\r\n", k, v)
+		}
+	}
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(body))
+	buf.WriteString("\r\n")
+
+	gz := gzip.NewWriter(w.f)
+	if _, err := io.WriteString(gz, buf.String()); err != nil {
+		gz.Close()
+		return err
+	}
+	if len(body) > 0 {
+		if _, err := gz.Write(body); err != nil {
+			gz.Close()
+			return err
+		}
+	}
+	if _, err := io.WriteString(gz, "\r\n\r\n"); err != nil {
+		gz.Close()
+		return err
+	}
+	return gz.Close()
+}
+
+var warcIDSeq uint64
+
+// newWarcID returns a process-unique WARC-Record-ID. It is not a real UUID,
+// just unique within this run, which is all WARC-Record-ID requires.
+func newWarcID() string {
+	n := atomic.AddUint64(&warcIDSeq, 1)
+	return fmt.Sprintf("<urn:mywayback:%d:%d>", time.Now().UnixNano(), n)
+}
+
+func sha1Base32(b []byte) string {
+	sum := sha1.Sum(b)
+	return base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// warcDate converts a 14-digit CDX timestamp (e.g. 20230102030405) to the
+// RFC3339 form WARC-Date requires, falling back to now if it can't parse.
+func warcDate(ts string) string {
+	t, err := time.Parse("20060102150405", ts)
+	if err != nil {
+		return time.Now().UTC().Format(time.RFC3339)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// syntheticRequest builds the minimal HTTP/1.1 request line + headers used
+// as the body of a WARC `request` record, since we don't have the original
+// archived request.
+func syntheticRequest(original string) []byte {
+	u, err := url.Parse(original)
+	path := original
+	host := ""
+	if err == nil {
+		host = u.Host
+		if u.RequestURI() != "" {
+			path = u.RequestURI()
+		}
+	}
+	return []byte(fmt.Sprintf("GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: mywayback\r\n\r\n", path, host))
+}
+
+// statusReasons maps common CDX status codes to their HTTP reason phrase.
+// HTTP/1.1 permits an empty reason phrase, so an unlisted code just leaves
+// it blank rather than guessing.
+var statusReasons = map[string]string{
+	"200": "OK",
+	"301": "Moved Permanently",
+	"302": "Found",
+	"304": "Not Modified",
+	"403": "Forbidden",
+	"404": "Not Found",
+	"500": "Internal Server Error",
+	"503": "Service Unavailable",
+}
+
+// syntheticResponse wraps payload in a synthetic HTTP/1.1 response message
+// (status line + headers + blank line + body) built from rec's CDX fields,
+// since a WARC `response` record declared Content-Type: application/http
+// must contain a full HTTP message, not a bare payload.
+func syntheticResponse(rec CDXRecord, payload []byte) []byte {
+	status := rec.StatusCode
+	if status == "" {
+		status = "200"
+	}
+	mimeType := rec.MimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "HTTP/1.1 %s %s\r\n", status, statusReasons[status])
+	fmt.Fprintf(&buf, "Content-Type: %s\r\n", mimeType)
+	fmt.Fprintf(&buf, "Content-Length: %d\r\n", len(payload))
+	buf.WriteString("\r\n")
+	return append([]byte(buf.String()), payload...)
+}
+
+// fetchWarcMode drives -fetch-warc / -fetch-content: it re-queries CDX for
+// extended fields, fetches each archived response body from Wayback, and
+// writes it into a per-worker WARC file, streams it to outWriter, or both.
+func fetchWarcMode(client *http.Client, pattern string, pages, pageWorkers, workers int, warcDir string, fetchContent bool, outWriter io.Writer, pbar Progress, filters CDXFilters) error {
+	if warcDir != "" {
+		if err := os.MkdirAll(warcDir, 0755); err != nil {
+			return fmt.Errorf("creating warc dir: %w", err)
+		}
+	}
+
+	recordsCh := make(chan CDXRecord, 2000)
+	var pagesCompleted int32
+
+	var fetchWg sync.WaitGroup
+	pageConcurrency := pageWorkers
+	if pageConcurrency < 1 {
+		pageConcurrency = 1
+	}
+	if pages < pageConcurrency {
+		pageConcurrency = pages
+	}
+	for i := 0; i < pageConcurrency; i++ {
+		fetchWg.Add(1)
+		go func(i int) {
+			defer fetchWg.Done()
+			var pageBytesTotal int64
+			var recsEmitted int64
+			workerStart := time.Now()
+			for p := range makePageJobs(pages, pageConcurrency, i) {
+				pageURL := "https://web.archive.org/cdx/search/cdx?url=" + url.QueryEscape(pattern) +
+					"&page=" + strconv.Itoa(p) +
+					"&fl=original,timestamp,statuscode,mimetype,length,digest&output=json" + filters.QueryString()
+				resp, err := client.Get(pageURL)
+				if err != nil {
+					pbar.Log(fmt.Sprintf("❌ ERROR fetching CDX page %d: %v", p, err), "\033[31m")
+					atomic.AddInt32(&pagesCompleted, 1)
+					pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
+					continue
+				}
+				recs, perr := parseCDXJSON(resp.Body)
+				resp.Body.Close()
+				if perr != nil {
+					pbar.Log(fmt.Sprintf("⚠ WARNING: error parsing CDX page %d: %v", p, perr), "\033[33m")
+				}
+				for _, rec := range recs {
+					recordsCh <- rec
+					pageBytesTotal += int64(len(rec.Original))
+					recsEmitted++
+				}
+				elapsed := time.Since(workerStart).Seconds()
+				recsPerSec := 0.0
+				if elapsed > 0 {
+					recsPerSec = float64(recsEmitted) / elapsed
+				}
+				pbar.UpdateWorker(i, p, pageBytesTotal, recsPerSec, 0)
+				atomic.AddInt32(&pagesCompleted, 1)
+				pbar.Render(int(atomic.LoadInt32(&pagesCompleted)))
+			}
+		}(i)
+	}
+	go func() {
+		fetchWg.Wait()
+		close(recordsCh)
+	}()
+
+	var digestsMu sync.Mutex
+	digestToID := make(map[string]string)
+	var outMu sync.Mutex
+
+	var workerWg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		workerWg.Add(1)
+		go func(idx int) {
+			defer workerWg.Done()
+			var ww *WarcWriter
+			if warcDir != "" {
+				path := filepath.Join(warcDir, fmt.Sprintf("worker-%d.warc.gz", idx))
+				w, err := NewWarcWriter(path, pattern)
+				if err != nil {
+					pbar.Log(fmt.Sprintf("❌ ERROR creating %s: %v", path, err), "\033[31m")
+					return
+				}
+				ww = w
+				defer ww.Close()
+			}
+
+			for rec := range recordsCh {
+				if rec.Original == "" {
+					continue
+				}
+
+				revisitWritten := false
+				if rec.Digest != "" {
+					digestsMu.Lock()
+					id, dup := digestToID[rec.Digest]
+					digestsMu.Unlock()
+					if dup {
+						if ww != nil {
+							if err := ww.WriteRevisit(rec, id); err != nil {
+								pbar.Log(fmt.Sprintf("⚠ WARNING: writing revisit for %s: %v", rec.Original, err), "\033[33m")
+							}
+							revisitWritten = true
+						}
+						// -fetch-content still needs the body even though the
+						// WARC side short-circuited to a revisit record, so
+						// fall through to fetch it rather than dropping it.
+						if !fetchContent {
+							continue
+						}
+					}
+				}
+
+				resp, err := client.Get(rec.ArchiveURL())
+				if err != nil {
+					pbar.Log(fmt.Sprintf("⚠ WARNING: fetching %s: %v", rec.Original, err), "\033[33m")
+					continue
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					pbar.Log(fmt.Sprintf("⚠ WARNING: reading body for %s: %v", rec.Original, err), "\033[33m")
+					continue
+				}
+
+				if ww != nil && !revisitWritten {
+					id, err := ww.WriteResponse(rec, body)
+					if err != nil {
+						pbar.Log(fmt.Sprintf("⚠ WARNING: writing WARC record for %s: %v", rec.Original, err), "\033[33m")
+					} else if rec.Digest != "" {
+						digestsMu.Lock()
+						digestToID[rec.Digest] = id
+						digestsMu.Unlock()
+					}
+				}
+
+				if fetchContent {
+					outMu.Lock()
+					fmt.Fprintf(outWriter, "# %s\n", rec.Original)
+					outWriter.Write(body)
+					fmt.Fprintln(outWriter)
+					outMu.Unlock()
+				}
+			}
+		}(i)
+	}
+	workerWg.Wait()
+	return nil
+}
+
+// makePageJobs splits [0,pages) into a channel of page numbers assigned to
+// fetcher goroutine index i out of concurrency total, round-robin.
+func makePageJobs(pages, concurrency, i int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for p := i; p < pages; p += concurrency {
+			ch <- p
+		}
+	}()
+	return ch
+}